@@ -0,0 +1,85 @@
+package smux
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// cmdType identifies the kind of a Frame.
+type cmdType byte
+
+const (
+	cmdSYN cmdType = iota // stream open
+	cmdFIN                // stream close
+	cmdPSH                // data push
+	cmdNOP                // keepalive
+	cmdUPD                // window update
+)
+
+const (
+	frameVersion = 1
+	headerSize   = 8 // ver(1) + cmd(1) + length(2) + sid(4)
+
+	// windowUpdateSize is the fixed payload size of a cmdUPD frame: a
+	// little-endian uint32 byte increment.
+	windowUpdateSize = 4
+)
+
+// A Frame is the basic unit exchanged over a Session's underlying
+// connection.
+type Frame struct {
+	ver  byte
+	cmd  cmdType
+	sid  uint32
+	data []byte
+}
+
+func newFrame(cmd cmdType, sid uint32) Frame {
+	return Frame{ver: frameVersion, cmd: cmd, sid: sid}
+}
+
+func newWindowUpdateFrame(sid uint32, increment uint32) Frame {
+	data := make([]byte, windowUpdateSize)
+	binary.LittleEndian.PutUint32(data, increment)
+	return Frame{ver: frameVersion, cmd: cmdUPD, sid: sid, data: data}
+}
+
+func (f Frame) windowIncrement() uint32 {
+	return binary.LittleEndian.Uint32(f.data)
+}
+
+func writeFrame(w io.Writer, f Frame) error {
+	var hdr [headerSize]byte
+	hdr[0] = f.ver
+	hdr[1] = byte(f.cmd)
+	binary.LittleEndian.PutUint16(hdr[2:4], uint16(len(f.data)))
+	binary.LittleEndian.PutUint32(hdr[4:8], f.sid)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if len(f.data) > 0 {
+		if _, err := w.Write(f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFrame(r io.Reader) (Frame, error) {
+	var hdr [headerSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return Frame{}, err
+	}
+	f := Frame{
+		ver: hdr[0],
+		cmd: cmdType(hdr[1]),
+		sid: binary.LittleEndian.Uint32(hdr[4:8]),
+	}
+	if n := binary.LittleEndian.Uint16(hdr[2:4]); n > 0 {
+		f.data = make([]byte, n)
+		if _, err := io.ReadFull(r, f.data); err != nil {
+			return Frame{}, err
+		}
+	}
+	return f, nil
+}
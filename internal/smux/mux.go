@@ -1,6 +1,7 @@
 package smux
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
@@ -32,6 +33,99 @@ type Config struct {
 	// on a write. This same value is used as a global timeout for writing a
 	// single frame to the connection.
 	WriteTimeout time.Duration
+
+	// Policy controls per-stream buffer sizing and backpressure behavior. If
+	// nil, DefaultPolicy is used.
+	Policy *Policy
+
+	// SchedulerPolicy selects how the session writer loop orders frames from
+	// ready streams when multiple streams have data to send.
+	SchedulerPolicy SchedulerPolicy
+}
+
+// A SchedulerPolicy selects how a Session's writer loop orders frames from
+// streams that are ready to send.
+type SchedulerPolicy int
+
+const (
+	// FIFO sends frames in the order their streams became ready, giving no
+	// stream precedence over another.
+	FIFO SchedulerPolicy = iota
+
+	// WeightedRoundRobin visits ready streams in a round-robin, granting
+	// each a share of the round proportional to its Stream.SetPriority
+	// weight.
+	WeightedRoundRobin
+
+	// StrictPriority always prefers the highest-priority ready stream,
+	// starving lower-priority streams until it has no data to send.
+	StrictPriority
+)
+
+// String implements fmt.Stringer.
+func (sp SchedulerPolicy) String() string {
+	switch sp {
+	case FIFO:
+		return "fifo"
+	case WeightedRoundRobin:
+		return "weighted-round-robin"
+	case StrictPriority:
+		return "strict-priority"
+	default:
+		return fmt.Sprintf("SchedulerPolicy(%d)", int(sp))
+	}
+}
+
+// A Policy tunes how a Session distributes and reclaims buffer space across
+// its streams, independently of the session-wide MaxReceiveBuffer.
+type Policy struct {
+	// PerStreamReceiveBuffer is the initial send window of each stream of
+	// this session, and half of it is the amount of drained read buffer at
+	// which a stream sends the peer a window update. The value is not
+	// exchanged with the peer, so the unread data a stream may receive is
+	// bounded by the peer's PerStreamReceiveBuffer, not by this one.
+	PerStreamReceiveBuffer int
+
+	// MaxConcurrentStreams caps the number of streams a session will keep
+	// open at once; additional Open calls block until a stream closes.
+	MaxConcurrentStreams int
+
+	// StreamOpenTimeout bounds how long OpenStream will wait for a free
+	// stream slot before giving up.
+	StreamOpenTimeout time.Duration
+
+	// BufferPerConnection must be at least PerStreamReceiveBuffer, which
+	// VerifyPolicy checks. A Session does not otherwise use it: the total
+	// buffer space across a session's streams is not capped by it.
+	BufferPerConnection int
+}
+
+// DefaultPolicy returns a permissive buffer policy suitable for most
+// sessions.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		PerStreamReceiveBuffer: 65536,
+		MaxConcurrentStreams:   256,
+		StreamOpenTimeout:      30 * time.Second,
+		BufferPerConnection:    4194304,
+	}
+}
+
+// VerifyPolicy is used to verify the sanity of a buffer policy.
+func VerifyPolicy(policy *Policy) error {
+	if policy.PerStreamReceiveBuffer <= 0 {
+		return errors.New("per-stream receive buffer must be positive")
+	}
+	if policy.MaxConcurrentStreams <= 0 {
+		return errors.New("max concurrent streams must be positive")
+	}
+	if policy.StreamOpenTimeout <= 0 {
+		return errors.New("stream open timeout must be positive")
+	}
+	if policy.BufferPerConnection < policy.PerStreamReceiveBuffer {
+		return errors.New("buffer per connection must be at least as large as the per-stream receive buffer")
+	}
+	return nil
 }
 
 // DefaultConfig is used to return a default configuration
@@ -43,6 +137,7 @@ func DefaultConfig() *Config {
 		MaxReceiveBuffer:  4194304,
 		ReadTimeout:       120 * time.Second,
 		WriteTimeout:      120 * time.Second,
+		Policy:            DefaultPolicy(),
 	}
 }
 
@@ -63,6 +158,14 @@ func VerifyConfig(config *Config) error {
 	if config.MaxReceiveBuffer <= 0 {
 		return errors.New("max receive buffer must be positive")
 	}
+	if config.Policy != nil {
+		if err := VerifyPolicy(config.Policy); err != nil {
+			return err
+		}
+	}
+	if config.SchedulerPolicy < FIFO || config.SchedulerPolicy > StrictPriority {
+		return fmt.Errorf("unknown scheduler policy %v", config.SchedulerPolicy)
+	}
 	return nil
 }
 
@@ -88,3 +191,36 @@ func Client(conn net.Conn, config *Config) (*Session, error) {
 	}
 	return newSession(config, conn, true), nil
 }
+
+// ClientContext is like Client, but uses the Config stored in ctx by
+// ContextWithConfig in place of the shared default, letting a single dial
+// override policy without mutating a process-wide Config.
+func ClientContext(ctx context.Context, conn net.Conn) (*Session, error) {
+	return Client(conn, ConfigFromContext(ctx))
+}
+
+// ServerContext is like Server, but uses the Config stored in ctx by
+// ContextWithConfig in place of the shared default, letting a single dial
+// override policy without mutating a process-wide Config.
+func ServerContext(ctx context.Context, conn net.Conn) (*Session, error) {
+	return Server(conn, ConfigFromContext(ctx))
+}
+
+// configContextKey is an unexported type to avoid collisions with context
+// keys from other packages.
+type configContextKey struct{}
+
+// ContextWithConfig returns a copy of ctx carrying config, allowing a single
+// dial to override the shared default Config without mutating it.
+func ContextWithConfig(ctx context.Context, config *Config) context.Context {
+	return context.WithValue(ctx, configContextKey{}, config)
+}
+
+// ConfigFromContext returns the Config stored in ctx by ContextWithConfig, or
+// DefaultConfig if ctx carries none.
+func ConfigFromContext(ctx context.Context) *Config {
+	if config, ok := ctx.Value(configContextKey{}).(*Config); ok {
+		return config
+	}
+	return DefaultConfig()
+}
@@ -2,6 +2,7 @@ package smux
 
 import (
 	"bytes"
+	"context"
 	"net"
 	"testing"
 	"time"
@@ -70,3 +71,103 @@ func TestConfig(t *testing.T) {
 		t.Fatal("client started with wrong config")
 	}
 }
+
+func TestVerifyPolicy(t *testing.T) {
+	if err := VerifyPolicy(DefaultPolicy()); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := DefaultPolicy()
+	policy.PerStreamReceiveBuffer = 0
+	if err := VerifyPolicy(policy); err == nil {
+		t.Fatal("expected error for zero per-stream receive buffer")
+	}
+
+	policy = DefaultPolicy()
+	policy.MaxConcurrentStreams = 0
+	if err := VerifyPolicy(policy); err == nil {
+		t.Fatal("expected error for zero max concurrent streams")
+	}
+
+	policy = DefaultPolicy()
+	policy.StreamOpenTimeout = 0
+	if err := VerifyPolicy(policy); err == nil {
+		t.Fatal("expected error for zero stream open timeout")
+	}
+
+	policy = DefaultPolicy()
+	policy.BufferPerConnection = policy.PerStreamReceiveBuffer - 1
+	if err := VerifyPolicy(policy); err == nil {
+		t.Fatal("expected error for buffer per connection smaller than per-stream buffer")
+	}
+
+	config := DefaultConfig()
+	config.Policy.MaxConcurrentStreams = 0
+	if err := VerifyConfig(config); err == nil {
+		t.Fatal("expected VerifyConfig to surface an invalid policy")
+	}
+}
+
+func TestSchedulerPolicy(t *testing.T) {
+	config := DefaultConfig()
+	if config.SchedulerPolicy != FIFO {
+		t.Fatal("default scheduler policy should be FIFO")
+	}
+
+	for _, sp := range []SchedulerPolicy{FIFO, WeightedRoundRobin, StrictPriority} {
+		config := DefaultConfig()
+		config.SchedulerPolicy = sp
+		if err := VerifyConfig(config); err != nil {
+			t.Fatalf("scheduler policy %v should be valid: %v", sp, err)
+		}
+	}
+
+	config = DefaultConfig()
+	config.SchedulerPolicy = StrictPriority + 1
+	if err := VerifyConfig(config); err == nil {
+		t.Fatal("expected error for unknown scheduler policy")
+	}
+}
+
+func TestConfigFromContext(t *testing.T) {
+	if ConfigFromContext(context.Background()).MaxFrameSize != DefaultConfig().MaxFrameSize {
+		t.Fatal("ConfigFromContext should fall back to DefaultConfig")
+	}
+
+	config := DefaultConfig()
+	config.MaxFrameSize = 1024
+	ctx := ContextWithConfig(context.Background(), config)
+	if got := ConfigFromContext(ctx); got != config {
+		t.Fatal("ConfigFromContext did not return the config stored by ContextWithConfig")
+	}
+}
+
+func TestClientServerContextUseContextConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Policy = DefaultPolicy()
+	config.Policy.PerStreamReceiveBuffer = 32
+	ctx := ContextWithConfig(context.Background(), config)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	client, err := ClientContext(ctx, c1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ServerContext(ctx, c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stream.sendWindow != int32(config.Policy.PerStreamReceiveBuffer) {
+		t.Fatalf("stream did not inherit the PerStreamReceiveBuffer from the context config: got sendWindow=%d, want %d", stream.sendWindow, config.Policy.PerStreamReceiveBuffer)
+	}
+}
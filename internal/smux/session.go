@@ -0,0 +1,412 @@
+package smux
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// errTimeout is returned when a deadline elapses before an operation could
+// complete.
+var errTimeout = errors.New("smux: i/o timeout")
+
+// errTooManyStreams is returned by OpenStream when StreamOpenTimeout elapses
+// while waiting for a free stream slot.
+var errTooManyStreams = errors.New("smux: timed out waiting for a free stream slot")
+
+// wrrQuantum is the deficit threshold a stream's accumulated priority must
+// cross before WeightedRoundRobin lets it send another frame.
+const wrrQuantum = 64
+
+// A Session multiplexes logical Streams over a single underlying
+// connection.
+type Session struct {
+	conn   net.Conn
+	config *Config
+	client bool
+
+	writeMu sync.Mutex // serializes frame writes onto conn
+
+	mu        sync.Mutex
+	streams   map[uint32]*Stream
+	nextID    uint32
+	openSlots chan struct{}
+
+	acceptCh chan *Stream
+
+	dieOnce sync.Once
+	dieCh   chan struct{}
+
+	schedMu    sync.Mutex
+	schedCond  *sync.Cond
+	readyOrder []*Stream
+	readySet   map[uint32]*Stream
+	deficits   map[*Stream]int32
+	wrrCursor  int
+}
+
+func newSession(config *Config, conn net.Conn, client bool) *Session {
+	policy := config.Policy
+	if policy == nil {
+		policy = DefaultPolicy()
+	}
+	sess := &Session{
+		conn:     conn,
+		config:   config,
+		client:   client,
+		streams:  make(map[uint32]*Stream),
+		acceptCh: make(chan *Stream, policy.MaxConcurrentStreams),
+		dieCh:    make(chan struct{}),
+		readySet: make(map[uint32]*Stream),
+		deficits: make(map[*Stream]int32),
+	}
+	sess.openSlots = make(chan struct{}, policy.MaxConcurrentStreams)
+	for i := 0; i < policy.MaxConcurrentStreams; i++ {
+		sess.openSlots <- struct{}{}
+	}
+	if client {
+		sess.nextID = 1
+	} else {
+		sess.nextID = 2
+	}
+	sess.schedCond = sync.NewCond(&sess.schedMu)
+	go sess.recvLoop()
+	go sess.sendLoop()
+	return sess
+}
+
+func (s *Session) policy() *Policy {
+	if s.config.Policy != nil {
+		return s.config.Policy
+	}
+	return DefaultPolicy()
+}
+
+func (s *Session) isDead() bool {
+	select {
+	case <-s.dieCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// OpenStream opens a new stream to the peer, blocking until a stream slot
+// is available or Policy.StreamOpenTimeout elapses.
+func (s *Session) OpenStream() (*Stream, error) {
+	timer := time.NewTimer(s.policy().StreamOpenTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.dieCh:
+		return nil, ErrStreamClosed
+	case <-timer.C:
+		return nil, errTooManyStreams
+	case <-s.openSlots:
+	}
+
+	s.mu.Lock()
+	if s.streams == nil {
+		s.mu.Unlock()
+		s.openSlots <- struct{}{}
+		return nil, ErrStreamClosed
+	}
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(id, s)
+	stream.tookSlot = true
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	// The SYN must reach the peer before any frame for this stream does,
+	// so it is written synchronously here rather than handed to a
+	// detached goroutine: a goroutine-dispatched SYN only shares writeMu
+	// with the sendLoop writer and a Close's FIN, with no guarantee it
+	// wins the race to be written first. That let a Write issued right
+	// after OpenStream land its data (or an immediate Close's FIN) on the
+	// wire ahead of the SYN, corrupting the stream or leaking it on the
+	// peer, which never learned the stream existed.
+	if err := s.writeFrameDirect(newFrame(cmdSYN, id)); err != nil {
+		s.removeStream(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// AcceptStream blocks until the peer opens a new stream, or the session is
+// closed.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case st := <-s.acceptCh:
+		return st, nil
+	case <-s.dieCh:
+		return nil, ErrStreamClosed
+	}
+}
+
+// Close terminates the session and all of its open streams.
+func (s *Session) Close() error {
+	s.dieOnce.Do(func() {
+		close(s.dieCh)
+		s.conn.Close()
+
+		s.mu.Lock()
+		streams := s.streams
+		s.streams = nil
+		s.mu.Unlock()
+		for _, st := range streams {
+			st.closeFromPeer()
+		}
+
+		s.schedMu.Lock()
+		s.schedCond.Broadcast()
+		s.schedMu.Unlock()
+	})
+	return nil
+}
+
+// removeStream drops id from the session's bookkeeping and, if the stream
+// had consumed a slot from openSlots, releases it back to the pool. A
+// stream that never took a slot (an inbound SYN rejected for lack of one)
+// must not return one either, or closing it would mint an extra slot and
+// let OpenStream exceed MaxConcurrentStreams.
+func (s *Session) removeStream(id uint32) {
+	s.mu.Lock()
+	var tookSlot bool
+	if s.streams != nil {
+		if st, ok := s.streams[id]; ok {
+			tookSlot = st.tookSlot
+		}
+		delete(s.streams, id)
+	}
+	s.mu.Unlock()
+	if tookSlot {
+		select {
+		case s.openSlots <- struct{}{}:
+		default:
+		}
+	}
+
+	s.schedMu.Lock()
+	if st, ok := s.readySet[id]; ok {
+		delete(s.readySet, id)
+		for i, o := range s.readyOrder {
+			if o == st {
+				s.readyOrder = append(s.readyOrder[:i], s.readyOrder[i+1:]...)
+				break
+			}
+		}
+		delete(s.deficits, st)
+	}
+	s.schedCond.Broadcast()
+	s.schedMu.Unlock()
+}
+
+// markReady marks st as having data to send, waking the writer loop.
+func (s *Session) markReady(st *Stream) {
+	s.schedMu.Lock()
+	if _, ok := s.readySet[st.id]; !ok {
+		s.readySet[st.id] = st
+		s.readyOrder = append(s.readyOrder, st)
+	}
+	s.schedCond.Broadcast()
+	s.schedMu.Unlock()
+}
+
+// writeFrameAsync writes a control frame (UPD/NOP) without waiting on the
+// data-plane scheduler. SYN and FIN are not sent this way: they must be
+// ordered relative to a stream's data and are written synchronously instead
+// (see Session.OpenStream and Stream.Close).
+func (s *Session) writeFrameAsync(f Frame) {
+	go func() {
+		_ = s.writeFrameDirect(f)
+	}()
+}
+
+func (s *Session) sendWindowUpdate(id uint32, increment uint32) {
+	s.writeFrameAsync(newWindowUpdateFrame(id, increment))
+}
+
+func (s *Session) writeFrameDirect(f Frame) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if d := s.config.WriteTimeout; d > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(d))
+		defer s.conn.SetWriteDeadline(time.Time{})
+	}
+	return writeFrame(s.conn, f)
+}
+
+// recvLoop reads frames off the connection and dispatches them to streams
+// until the connection fails or the session is closed.
+func (s *Session) recvLoop() {
+	defer s.Close()
+	for {
+		f, err := readFrame(s.conn)
+		if err != nil {
+			return
+		}
+		switch f.cmd {
+		case cmdSYN:
+			s.mu.Lock()
+			if s.streams == nil {
+				s.mu.Unlock()
+				return
+			}
+			var tookSlot bool
+			select {
+			case <-s.openSlots:
+				tookSlot = true
+			default:
+				// No free slot: MaxConcurrentStreams applies to inbound
+				// streams too, so reject rather than silently exceeding it.
+			}
+			if !tookSlot {
+				s.mu.Unlock()
+				_ = s.writeFrameDirect(newFrame(cmdFIN, f.sid))
+				continue
+			}
+			stream := newStream(f.sid, s)
+			stream.tookSlot = true
+			s.streams[f.sid] = stream
+			s.mu.Unlock()
+			select {
+			case s.acceptCh <- stream:
+			case <-s.dieCh:
+				return
+			}
+		case cmdFIN:
+			s.mu.Lock()
+			stream := s.streams[f.sid]
+			s.mu.Unlock()
+			if stream != nil {
+				stream.closeFromPeer()
+				s.removeStream(f.sid)
+			}
+		case cmdPSH:
+			s.mu.Lock()
+			stream := s.streams[f.sid]
+			s.mu.Unlock()
+			if stream != nil {
+				stream.pushData(f.data)
+			}
+		case cmdUPD:
+			if len(f.data) < windowUpdateSize {
+				// A conforming peer never sends a cmdUPD shorter than its
+				// fixed payload; treat it as a protocol violation rather
+				// than panicking on the short read inside windowIncrement.
+				return
+			}
+			s.mu.Lock()
+			stream := s.streams[f.sid]
+			s.mu.Unlock()
+			if stream != nil {
+				stream.grantPeerWindow(f.windowIncrement())
+			}
+		case cmdNOP:
+		}
+	}
+}
+
+// sendLoop is the session's single writer: it repeatedly asks the
+// scheduler for the next stream permitted to send, and writes one
+// frame-worth of its queued data.
+func (s *Session) sendLoop() {
+	for {
+		st, ok := s.pickNext()
+		if !ok {
+			return
+		}
+		frame, more := st.popWriteFrame(s.config.MaxFrameSize)
+		if len(frame.data) > 0 {
+			if err := s.writeFrameDirect(frame); err != nil {
+				s.Close()
+				return
+			}
+		}
+		if more {
+			s.markReady(st)
+		}
+	}
+}
+
+// pickNext blocks until a stream is both ready (has queued data) and
+// permitted to send (has send window available), then removes it from the
+// ready set according to Config.SchedulerPolicy.
+func (s *Session) pickNext() (*Stream, bool) {
+	s.schedMu.Lock()
+	defer s.schedMu.Unlock()
+	for {
+		if idx, st, ok := s.selectIndexLocked(); ok {
+			s.readyOrder = append(s.readyOrder[:idx], s.readyOrder[idx+1:]...)
+			delete(s.readySet, st.id)
+			return st, true
+		}
+		if s.isDead() {
+			return nil, false
+		}
+		s.schedCond.Wait()
+	}
+}
+
+func (s *Session) selectIndexLocked() (int, *Stream, bool) {
+	switch s.config.SchedulerPolicy {
+	case StrictPriority:
+		best := -1
+		var bestStream *Stream
+		for i, st := range s.readyOrder {
+			if !st.canSend() {
+				continue
+			}
+			if best == -1 || st.priority > bestStream.priority {
+				best = i
+				bestStream = st
+			}
+		}
+		if best == -1 {
+			return 0, nil, false
+		}
+		return best, bestStream, true
+	case WeightedRoundRobin:
+		return s.selectWRRLocked()
+	default: // FIFO
+		for i, st := range s.readyOrder {
+			if st.canSend() {
+				return i, st, true
+			}
+		}
+		return 0, nil, false
+	}
+}
+
+// selectWRRLocked implements deficit round robin: each pass over the ready
+// set, a stream accrues deficit equal to its priority weight, and is
+// selected once its deficit clears wrrQuantum. This gives streams send
+// share proportional to their weight under contention.
+func (s *Session) selectWRRLocked() (int, *Stream, bool) {
+	n := len(s.readyOrder)
+	if n == 0 {
+		return 0, nil, false
+	}
+	for tries := 0; tries < n; tries++ {
+		idx := (s.wrrCursor + tries) % n
+		st := s.readyOrder[idx]
+		if !st.canSend() {
+			continue
+		}
+		s.deficits[st] += int32(st.priority)
+		if s.deficits[st] >= wrrQuantum {
+			s.deficits[st] -= wrrQuantum
+			s.wrrCursor = idx
+			return idx, st, true
+		}
+	}
+	// No stream has crossed the quantum yet this pass; force progress by
+	// serving the first eligible stream rather than stalling.
+	for i, st := range s.readyOrder {
+		if st.canSend() {
+			return i, st, true
+		}
+	}
+	return 0, nil, false
+}
@@ -0,0 +1,372 @@
+package smux
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// slowConn wraps a net.Conn and adds a fixed delay before every Write,
+// simulating a bandwidth-limited link so that contention between streams
+// persists long enough for the scheduler's choices to be observable.
+type slowConn struct {
+	net.Conn
+	delay time.Duration
+}
+
+func (c *slowConn) Write(p []byte) (int, error) {
+	time.Sleep(c.delay)
+	return c.Conn.Write(p)
+}
+
+func newTestSessionPair(t *testing.T, configure func(*Config)) (*Session, *Session) {
+	t.Helper()
+	return newTestSessionPairWithWriteDelay(t, 0, configure)
+}
+
+func newTestSessionPairWithWriteDelay(t *testing.T, writeDelay time.Duration, configure func(*Config)) (*Session, *Session) {
+	t.Helper()
+	c1, c2 := net.Pipe()
+	config := DefaultConfig()
+	if configure != nil {
+		configure(config)
+	}
+	var clientConn net.Conn = c1
+	if writeDelay > 0 {
+		clientConn = &slowConn{Conn: c1, delay: writeDelay}
+	}
+	client, err := Client(clientConn, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := Server(c2, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestBackpressureWindowUpdate(t *testing.T) {
+	client, server := newTestSessionPair(t, func(c *Config) {
+		c.Policy.PerStreamReceiveBuffer = 64
+	})
+
+	clientStream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverStream, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sender's initial window equals PerStreamReceiveBuffer; sending
+	// more than that without the receiver reading would block forever
+	// without a window update, so run the write in the background.
+	payload := make([]byte, 10*64)
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := clientStream.Write(payload)
+		writeErr <- err
+	}()
+
+	buf := make([]byte, len(payload))
+	read := 0
+	for read < len(payload) {
+		n, err := serverStream.Read(buf[read:])
+		if err != nil {
+			t.Fatalf("read failed after %d bytes: %v", read, err)
+		}
+		read += n
+	}
+
+	select {
+	case err := <-writeErr:
+		if err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write never drained; backpressure window update did not unblock the sender")
+	}
+}
+
+func TestWindowExhaustionBlocksSender(t *testing.T) {
+	client, server := newTestSessionPair(t, func(c *Config) {
+		c.Policy.PerStreamReceiveBuffer = 64
+		c.WriteTimeout = 100 * time.Millisecond
+	})
+
+	clientStream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nobody ever reads the server-side stream, so after the initial
+	// window is exhausted the sender must block and ultimately time out,
+	// rather than buffering unboundedly past PerStreamReceiveBuffer.
+	_, err = clientStream.Write(make([]byte, 10*64))
+	if err != errTimeout {
+		t.Fatalf("expected errTimeout once the receive window was exhausted, got %v", err)
+	}
+}
+
+func TestOpenStreamRespectsMaxConcurrentStreams(t *testing.T) {
+	client, _ := newTestSessionPair(t, func(c *Config) {
+		c.Policy.MaxConcurrentStreams = 1
+		c.Policy.StreamOpenTimeout = 50 * time.Millisecond
+	})
+
+	if _, err := client.OpenStream(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.OpenStream(); err != errTooManyStreams {
+		t.Fatalf("expected errTooManyStreams once MaxConcurrentStreams was exhausted, got %v", err)
+	}
+}
+
+// TestWriteImmediatelyAfterOpenStreamIsNotLost guards against the SYN/data
+// ordering race: if OpenStream's SYN were dispatched from a detached
+// goroutine, a Write issued right after OpenStream returns could win the
+// race for writeMu and reach the peer before the SYN, which would silently
+// drop the data (recvLoop has no stream to deliver it to yet) even though
+// Write reports success.
+func TestWriteImmediatelyAfterOpenStreamIsNotLost(t *testing.T) {
+	client, server := newTestSessionPair(t, nil)
+
+	stream, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte("hello before accept")
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := stream.Write(payload)
+		writeErr <- err
+	}()
+
+	serverStream, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(serverStream, buf); err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(buf) != string(payload) {
+		t.Fatalf("got %q, want %q", buf, payload)
+	}
+	if err := <-writeErr; err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+}
+
+// TestRecvLoopRejectsShortWindowUpdate ensures a cmdUPD frame whose payload
+// is shorter than windowUpdateSize closes the session instead of panicking
+// recvLoop: windowIncrement decodes a fixed 4-byte uint32, and a
+// hostile or buggy peer can send an arbitrarily short payload.
+func TestRecvLoopRejectsShortWindowUpdate(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	session, err := Client(c1, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	var hdr [headerSize]byte
+	hdr[0] = frameVersion
+	hdr[1] = byte(cmdUPD)
+	binary.LittleEndian.PutUint16(hdr[2:4], 1) // payload shorter than windowUpdateSize
+	binary.LittleEndian.PutUint32(hdr[4:8], 1)
+	go func() {
+		c2.Write(hdr[:])
+		c2.Write([]byte{0})
+	}()
+
+	select {
+	case <-session.dieCh:
+	case <-time.After(time.Second):
+		t.Fatal("session did not close after a malformed cmdUPD frame")
+	}
+}
+
+// TestInboundStreamRespectsMaxConcurrentStreams guards against recvLoop
+// creating inbound streams without consuming an openSlots token: if it did,
+// MaxConcurrentStreams would never bound accepted streams, and removeStream
+// returning a slot for every closed stream (rather than only ones that took
+// one) would let OpenStream mint slots beyond the configured cap once an
+// inbound stream closed.
+func TestInboundStreamRespectsMaxConcurrentStreams(t *testing.T) {
+	c1, c2 := net.Pipe()
+	clientConfig := DefaultConfig()
+	clientConfig.Policy.MaxConcurrentStreams = 8
+	serverConfig := DefaultConfig()
+	serverConfig.Policy.MaxConcurrentStreams = 1
+
+	client, err := Client(c1, clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := Server(c2, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	first, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.AcceptStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server's single slot is held by the accepted stream, so a second
+	// SYN must be rejected (via FIN) rather than accepted over the cap.
+	second, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.SetWriteDeadline(time.Now().Add(200 * time.Millisecond))
+	select {
+	case <-second.dieCh:
+	case <-time.After(time.Second):
+		t.Fatal("server accepted a second inbound stream beyond its MaxConcurrentStreams")
+	}
+
+	// Closing the first stream frees its slot, so a subsequent SYN must now
+	// succeed rather than being rejected again.
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	third, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := server.AcceptStream()
+		accepted <- err
+	}()
+	select {
+	case err := <-accepted:
+		if err != nil {
+			t.Fatalf("AcceptStream failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not accept a stream after freeing its only slot")
+	}
+	_ = third
+}
+
+// runContendedTransfer starts two streams continuously writing over a
+// bandwidth-limited connection and returns how many bytes of each reached
+// the peer within the sampling window, measuring the scheduler's effect on
+// service share under sustained contention.
+func runContendedTransfer(t *testing.T, schedulerPolicy SchedulerPolicy, configureStreams func(a, b *Stream)) (aBytes, bBytes int) {
+	t.Helper()
+	client, server := newTestSessionPairWithWriteDelay(t, 2*time.Millisecond, func(c *Config) {
+		c.SchedulerPolicy = schedulerPolicy
+		c.Policy.PerStreamReceiveBuffer = 1 << 20
+		c.MaxFrameSize = 256
+	})
+
+	a, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := client.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Correlate server-side streams with a and b by ID rather than by
+	// arrival order.
+	first, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := server.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var aServer, bServer *Stream
+	if first.ID() == a.ID() {
+		aServer, bServer = first, second
+	} else {
+		aServer, bServer = second, first
+	}
+	configureStreams(a, b)
+
+	stop := make(chan struct{})
+	chunk := make([]byte, 256)
+	feed := func(s *Stream) {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.Write(chunk)
+		}
+	}
+	go feed(a)
+	go feed(b)
+
+	const window = 300 * time.Millisecond
+	time.Sleep(window)
+	close(stop)
+
+	drain := func(s *Stream) int {
+		s.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		buf := make([]byte, 4096)
+		total := 0
+		for {
+			n, err := s.Read(buf)
+			total += n
+			if err != nil {
+				return total
+			}
+		}
+	}
+	return drain(aServer), drain(bServer)
+}
+
+func TestStrictPriorityStarvesLowerPriority(t *testing.T) {
+	aBytes, bBytes := runContendedTransfer(t, StrictPriority, func(a, b *Stream) {
+		a.SetPriority(255)
+		b.SetPriority(0)
+	})
+	if aBytes == 0 {
+		t.Fatal("high-priority stream made no progress")
+	}
+	if bBytes > aBytes/4 {
+		t.Fatalf("expected the low-priority stream to be starved while the high-priority stream had data, got high=%d low=%d", aBytes, bBytes)
+	}
+}
+
+func TestWeightedRoundRobinProportionalService(t *testing.T) {
+	aBytes, bBytes := runContendedTransfer(t, WeightedRoundRobin, func(a, b *Stream) {
+		a.SetPriority(200)
+		b.SetPriority(20)
+	})
+	if aBytes == 0 || bBytes == 0 {
+		t.Fatalf("expected both streams to make progress, got heavy=%d light=%d", aBytes, bBytes)
+	}
+	ratio := float64(aBytes) / float64(bBytes)
+	// Weights are 200:20 (10:1); allow generous slack for scheduling noise.
+	if ratio < 3 {
+		t.Fatalf("expected the heavier-weighted stream to receive substantially more service, got ratio %.2f (heavy=%d light=%d)", ratio, aBytes, bBytes)
+	}
+}
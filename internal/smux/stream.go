@@ -0,0 +1,271 @@
+package smux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStreamClosed is returned by Stream.Read and Stream.Write after the
+// stream has been closed.
+var ErrStreamClosed = errors.New("smux: stream closed")
+
+// defaultPriority is the weight assigned to a stream that has not called
+// SetPriority.
+const defaultPriority = 128
+
+// A Stream is a logical, bidirectional flow of bytes multiplexed over a
+// Session's underlying connection.
+type Stream struct {
+	id   uint32
+	sess *Session
+
+	// tookSlot records whether this stream consumed a slot from
+	// sess.openSlots, so removeStream knows whether to return one. It is
+	// set once at creation and read only while sess.mu is held.
+	tookSlot bool
+
+	priority uint32 // accessed only while sess.schedMu is held
+
+	writeMu  sync.Mutex
+	writeBuf bytes.Buffer
+
+	sendWindow int32 // atomic: bytes we are currently permitted to send
+
+	readMu    sync.Mutex
+	readBuf   bytes.Buffer
+	readEvent chan struct{}
+
+	recvWindow int32 // atomic: unflushed read-buffer credit owed to the peer
+
+	closeOnce sync.Once
+	dieCh     chan struct{}
+
+	writeDeadline time.Time
+	readDeadline  time.Time
+	deadlineMu    sync.Mutex
+}
+
+func newStream(id uint32, sess *Session) *Stream {
+	return &Stream{
+		id:         id,
+		sess:       sess,
+		priority:   defaultPriority + 1, // stored as weight+1, as in SetPriority
+		readEvent:  make(chan struct{}, 1),
+		dieCh:      make(chan struct{}),
+		sendWindow: int32(sess.policy().PerStreamReceiveBuffer),
+	}
+}
+
+// ID returns the stream's identifier, unique within its Session.
+func (s *Stream) ID() uint32 { return s.id }
+
+// SetPriority sets the stream's scheduling weight. Higher weights receive a
+// larger share of the session's outbound bandwidth under
+// WeightedRoundRobin, and are preferred outright under StrictPriority. The
+// default weight is 128.
+func (s *Stream) SetPriority(weight uint8) {
+	s.sess.schedMu.Lock()
+	s.priority = uint32(weight) + 1 // keep weight >=1 so a 0-weight stream still makes progress
+	s.sess.schedMu.Unlock()
+}
+
+// SetWriteDeadline sets the deadline for future Write calls. A zero value
+// disables the per-call deadline, leaving Config.WriteTimeout in effect.
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.deadlineMu.Lock()
+	s.writeDeadline = t
+	s.deadlineMu.Unlock()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future Read calls. A zero value
+// disables the deadline.
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.deadlineMu.Lock()
+	s.readDeadline = t
+	s.deadlineMu.Unlock()
+	return nil
+}
+
+// Write queues p to be sent to the peer and blocks until it has drained
+// from the stream's outbound buffer onto the connection. It respects both
+// the stream's own WriteDeadline and Config.WriteTimeout, so a stalled
+// stream cannot block the caller indefinitely if the underlying connection
+// or the peer's receive window wedges.
+func (s *Stream) Write(p []byte) (int, error) {
+	select {
+	case <-s.dieCh:
+		return 0, ErrStreamClosed
+	default:
+	}
+
+	s.writeMu.Lock()
+	n, _ := s.writeBuf.Write(p)
+	s.writeMu.Unlock()
+	s.sess.markReady(s)
+
+	deadline := s.sess.config.WriteTimeout
+	hasDeadline := deadline > 0
+	s.deadlineMu.Lock()
+	if !s.writeDeadline.IsZero() {
+		if d := time.Until(s.writeDeadline); !hasDeadline || d < deadline {
+			deadline = d
+		}
+		hasDeadline = true
+	}
+	s.deadlineMu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if hasDeadline {
+		timer := time.NewTimer(deadline)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.writeMu.Lock()
+		empty := s.writeBuf.Len() == 0
+		s.writeMu.Unlock()
+		if empty {
+			return n, nil
+		}
+		select {
+		case <-s.dieCh:
+			return n, ErrStreamClosed
+		case <-timeoutCh:
+			return n, errTimeout
+		case <-ticker.C:
+		}
+	}
+}
+
+// canSend reports whether the stream has queued data it is currently
+// permitted to send, given its remaining send window.
+func (s *Stream) canSend() bool {
+	s.writeMu.Lock()
+	pending := s.writeBuf.Len() > 0
+	s.writeMu.Unlock()
+	return pending && atomic.LoadInt32(&s.sendWindow) > 0
+}
+
+// popWriteFrame removes up to max bytes (bounded by the remaining send
+// window) from the stream's outbound buffer and returns it as a data Frame,
+// along with whether more data remains queued.
+func (s *Stream) popWriteFrame(max int) (Frame, bool) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	avail := int(atomic.LoadInt32(&s.sendWindow))
+	n := s.writeBuf.Len()
+	if n > max {
+		n = max
+	}
+	if n > avail {
+		n = avail
+	}
+	var data []byte
+	if n > 0 {
+		data = make([]byte, n)
+		s.writeBuf.Read(data)
+		atomic.AddInt32(&s.sendWindow, int32(-n))
+	}
+	f := newFrame(cmdPSH, s.id)
+	f.data = data
+	return f, s.writeBuf.Len() > 0
+}
+
+// grantPeerWindow records a window update received from the peer, allowing
+// the stream to resume sending if it was blocked.
+func (s *Stream) grantPeerWindow(n uint32) {
+	atomic.AddInt32(&s.sendWindow, int32(n))
+	s.sess.markReady(s)
+}
+
+// pushData delivers data received from the peer into the stream's read
+// buffer, waking any blocked Read.
+func (s *Stream) pushData(data []byte) {
+	s.readMu.Lock()
+	s.readBuf.Write(data)
+	s.readMu.Unlock()
+	select {
+	case s.readEvent <- struct{}{}:
+	default:
+	}
+}
+
+// Read reads data from the stream into p, blocking until at least one byte
+// is available, ReadDeadline elapses, or the stream closes. As enough data
+// drains from the read buffer, Read emits a window-update frame so the peer
+// can resume sending without waiting for the buffer to fill completely.
+func (s *Stream) Read(p []byte) (int, error) {
+	s.deadlineMu.Lock()
+	deadline := s.readDeadline
+	s.deadlineMu.Unlock()
+
+	var timeoutCh <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	for {
+		s.readMu.Lock()
+		if s.readBuf.Len() > 0 {
+			n, _ := s.readBuf.Read(p)
+			s.readMu.Unlock()
+			s.grantWindow(n)
+			return n, nil
+		}
+		s.readMu.Unlock()
+
+		select {
+		case <-s.dieCh:
+			return 0, io.EOF
+		case <-timeoutCh:
+			return 0, errTimeout
+		case <-s.readEvent:
+		}
+	}
+}
+
+// grantWindow banks n bytes of newly-freed receive buffer and, once half of
+// PerStreamReceiveBuffer has accumulated, flushes it to the peer as a
+// window-update frame.
+func (s *Stream) grantWindow(n int) {
+	threshold := int32(s.sess.policy().PerStreamReceiveBuffer / 2)
+	if threshold <= 0 {
+		threshold = 1
+	}
+	if atomic.AddInt32(&s.recvWindow, int32(n)) >= threshold {
+		if increment := atomic.SwapInt32(&s.recvWindow, 0); increment > 0 {
+			s.sess.sendWindowUpdate(s.id, uint32(increment))
+		}
+	}
+}
+
+// Close closes the stream. Any data still queued in the outbound buffer is
+// discarded.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.dieCh)
+		s.sess.removeStream(s.id)
+		// Written synchronously so a FIN can never overtake this stream's
+		// own SYN; see the comment in Session.OpenStream.
+		s.sess.writeFrameDirect(newFrame(cmdFIN, s.id))
+	})
+	return nil
+}
+
+// closeFromPeer marks the stream dead in response to a cmdFIN or session
+// teardown, without re-entering the session's bookkeeping.
+func (s *Stream) closeFromPeer() {
+	s.closeOnce.Do(func() {
+		close(s.dieCh)
+	})
+}
@@ -0,0 +1,306 @@
+// Package policy implements a miniscript-style compiler and cost analyzer
+// for types.SpendPolicy trees, so that wallets can pick the cheapest spend
+// path and validate user-authored policies before publishing an address.
+package policy
+
+import (
+	"fmt"
+	"sort"
+
+	"go.sia.tech/core/v2/types"
+)
+
+// legacyUnlockConditionsCost is the witness cost charged to an opaque
+// PolicyTypeUnlockConditions leaf. UnlockConditions are never decomposed by
+// the analyzer, since satisfying them depends on details (timelock,
+// signature algorithm) that are opaque to the policy tree.
+const legacyUnlockConditionsCost = 65 + 8
+
+// maxHashPreimage is the assumed size, in bytes, of a PolicyTypeHash
+// preimage, for cost-estimation purposes.
+const maxHashPreimage = 32
+
+// A Branch describes one satisfiable path through a SpendPolicy tree.
+type Branch struct {
+	WitnessBytes int
+	Signatures   int
+}
+
+// Stats reports static cost metrics for a SpendPolicy. Branches is nil if p
+// has more satisfying combinations than maxEnumeratedBranches; see Analyze.
+type Stats struct {
+	MaxWitnessBytes int
+	MinSignatures   int
+	Branches        []Branch
+}
+
+// A Witness is a minimal satisfaction plan for a SpendPolicy: the set of
+// keys that must sign, and the number of hash preimages that must be
+// revealed, in order to satisfy it.
+type Witness struct {
+	Keys         []types.PublicKey
+	NumPreimages int
+}
+
+type leafCost struct {
+	bytes int
+	sigs  int
+}
+
+// maxEnumeratedBranches bounds how many satisfying combinations Analyze will
+// materialize into Stats.Branches. A thresh(N,[M children]) has C(M,N)
+// satisfying combinations, which is combinatorial in M; since DecodeFrom
+// allows up to maxThresholdChildren (1024) sub-policies, enumerating every
+// combination of a user-authored policy is a denial-of-service vector. Past
+// this bound, Analyze still reports MaxWitnessBytes/MinSignatures (computed
+// without enumeration, see cost), but omits Branches rather than exhausting
+// memory to build it.
+const maxEnumeratedBranches = 4096
+
+// cost computes a SpendPolicy's worst-case witness byte size and best-case
+// signature count without enumerating its N-of-M combinations: a
+// threshold's worst byte cost is the sum of its N most expensive children's
+// own worst costs, and its best signature count the sum of its N cheapest
+// children's own best counts. Each is an independent per-child optimization
+// (the cheapest-by-bytes child of a threshold need not be the one with the
+// fewest signatures), computable by sorting in O(M log M) rather than by
+// generating every branch.
+func cost(p types.SpendPolicy) (leafCost, error) {
+	switch p := p.Type.(type) {
+	case types.PolicyTypeAbove, types.PolicyTypeBefore:
+		return leafCost{}, nil
+	case types.PolicyTypePublicKey:
+		return leafCost{bytes: 64, sigs: 1}, nil
+	case types.PolicyTypeHash:
+		return leafCost{bytes: maxHashPreimage}, nil
+	case types.PolicyTypeUnlockConditions:
+		return leafCost{bytes: legacyUnlockConditionsCost, sigs: int(p.SignaturesRequired)}, nil
+	case types.PolicyTypeThreshold:
+		if int(p.N) > len(p.Of) {
+			return leafCost{}, fmt.Errorf("threshold %d of %d is unsatisfiable", p.N, len(p.Of))
+		}
+		maxBytes := make([]int, len(p.Of))
+		minSigs := make([]int, len(p.Of))
+		for i, sp := range p.Of {
+			c, err := cost(sp)
+			if err != nil {
+				return leafCost{}, err
+			}
+			maxBytes[i] = c.bytes
+			minSigs[i] = c.sigs
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(maxBytes)))
+		sort.Ints(minSigs)
+		var out leafCost
+		for i := 0; i < int(p.N); i++ {
+			out.bytes += maxBytes[i]
+			out.sigs += minSigs[i]
+		}
+		return out, nil
+	default:
+		return leafCost{}, fmt.Errorf("unsupported policy type %T", p)
+	}
+}
+
+// branches enumerates up to limit satisfying paths through p: a single
+// branch for a leaf, and one branch per N-of-M combination of child
+// branches for a threshold. If p has more satisfying combinations than
+// limit, branches returns (nil, nil): Branches is diagnostic, so a partial,
+// arbitrarily-chosen subset of combinations would be misleading, and the
+// full set is exactly the combinatorial blowup limit exists to avoid.
+func branches(p types.SpendPolicy, limit int) ([]leafCost, error) {
+	switch p := p.Type.(type) {
+	case types.PolicyTypeAbove, types.PolicyTypeBefore:
+		return []leafCost{{}}, nil
+	case types.PolicyTypePublicKey:
+		return []leafCost{{bytes: 64, sigs: 1}}, nil
+	case types.PolicyTypeHash:
+		return []leafCost{{bytes: maxHashPreimage}}, nil
+	case types.PolicyTypeUnlockConditions:
+		return []leafCost{{bytes: legacyUnlockConditionsCost, sigs: int(p.SignaturesRequired)}}, nil
+	case types.PolicyTypeThreshold:
+		if int(p.N) > len(p.Of) {
+			return nil, fmt.Errorf("threshold %d of %d is unsatisfiable", p.N, len(p.Of))
+		}
+		childBranches := make([][]leafCost, len(p.Of))
+		for i, sp := range p.Of {
+			cb, err := branches(sp, limit)
+			if err != nil {
+				return nil, err
+			}
+			if cb == nil {
+				return nil, nil
+			}
+			childBranches[i] = cb
+		}
+		var out []leafCost
+		aborted := false
+		var choose func(start, remaining int, acc leafCost)
+		choose = func(start, remaining int, acc leafCost) {
+			if aborted {
+				return
+			}
+			if remaining == 0 {
+				out = append(out, acc)
+				if len(out) > limit {
+					aborted = true
+				}
+				return
+			}
+			for i := start; i <= len(childBranches)-remaining && !aborted; i++ {
+				for _, c := range childBranches[i] {
+					choose(i+1, remaining-1, leafCost{bytes: acc.bytes + c.bytes, sigs: acc.sigs + c.sigs})
+					if aborted {
+						break
+					}
+				}
+			}
+		}
+		choose(0, int(p.N), leafCost{})
+		if aborted {
+			return nil, nil
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("unsupported policy type %T", p)
+	}
+}
+
+// sane reports an error if p reuses the same key anywhere in its tree
+// (including across different nesting levels, not just within a single
+// threshold's direct children), or combines an above and a before
+// sub-policy (within a threshold requiring both) that can never be jointly
+// satisfied.
+func sane(p types.SpendPolicy) error {
+	return saneWalk(p, make(map[types.PublicKey]bool))
+}
+
+// saneWalk is sane's recursive implementation. seen is threaded through the
+// entire walk rather than reset per threshold level, so a key reused at a
+// different nesting depth (e.g. thresh(1,[pk(A), thresh(1,[pk(A)])])) is
+// still caught.
+func saneWalk(p types.SpendPolicy, seen map[types.PublicKey]bool) error {
+	switch t := p.Type.(type) {
+	case types.PolicyTypePublicKey:
+		pk := types.PublicKey(t)
+		if seen[pk] {
+			return fmt.Errorf("duplicate key %x in policy", pk)
+		}
+		seen[pk] = true
+	case types.PolicyTypeThreshold:
+		var above, before *uint64
+		for _, sp := range t.Of {
+			if err := saneWalk(sp, seen); err != nil {
+				return err
+			}
+			switch c := sp.Type.(type) {
+			case types.PolicyTypeAbove:
+				h := uint64(c)
+				above = &h
+			case types.PolicyTypeBefore:
+				h := uint64(c)
+				before = &h
+			}
+		}
+		if int(t.N) == len(t.Of) && above != nil && before != nil && *above >= *before {
+			return fmt.Errorf("above(%d) and before(%d) can never be jointly satisfied", *above, *before)
+		}
+	case types.PolicyTypeUnlockConditions, types.PolicyTypeAbove, types.PolicyTypeBefore, types.PolicyTypeHash:
+	default:
+		return fmt.Errorf("unsupported policy type %T", p)
+	}
+	return nil
+}
+
+// Analyze reports p's static cost metrics, returning an error if p is not
+// sane. MaxWitnessBytes and MinSignatures are always computed in O(M log M)
+// per threshold; Stats.Branches additionally enumerates p's individual
+// satisfying combinations, but is left nil if there are more of them than
+// maxEnumeratedBranches.
+func Analyze(p types.SpendPolicy) (Stats, error) {
+	if err := sane(p); err != nil {
+		return Stats{}, err
+	}
+	c, err := cost(p)
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{
+		MaxWitnessBytes: c.bytes,
+		MinSignatures:   c.sigs,
+	}
+	bs, err := branches(p, maxEnumeratedBranches)
+	if err != nil {
+		return Stats{}, err
+	}
+	if bs != nil {
+		stats.Branches = make([]Branch, len(bs))
+		for i, b := range bs {
+			stats.Branches[i] = Branch{WitnessBytes: b.bytes, Signatures: b.sigs}
+		}
+	}
+	return stats, nil
+}
+
+// Satisfactions enumerates the witnesses that currently satisfy p, given
+// the keys a wallet holds and the current chain height. A threshold yields
+// one witness per combination of N satisfiable children; a hash leaf is
+// always assumed satisfiable, since revealing its preimage is the prover's
+// own secret.
+func Satisfactions(p types.SpendPolicy, haveKeys map[types.PublicKey]bool, height uint64) []Witness {
+	switch p := p.Type.(type) {
+	case types.PolicyTypeAbove:
+		if height >= uint64(p) {
+			return []Witness{{}}
+		}
+		return nil
+	case types.PolicyTypeBefore:
+		if height < uint64(p) {
+			return []Witness{{}}
+		}
+		return nil
+	case types.PolicyTypePublicKey:
+		pk := types.PublicKey(p)
+		if !haveKeys[pk] {
+			return nil
+		}
+		return []Witness{{Keys: []types.PublicKey{pk}}}
+	case types.PolicyTypeHash:
+		return []Witness{{NumPreimages: 1}}
+	case types.PolicyTypeUnlockConditions:
+		return []Witness{{}}
+	case types.PolicyTypeThreshold:
+		return thresholdSatisfactions(p, haveKeys, height)
+	default:
+		return nil
+	}
+}
+
+// thresholdSatisfactions enumerates every way to merge the witnesses of N of
+// p.Of's children into a single satisfying Witness for the threshold.
+func thresholdSatisfactions(p types.PolicyTypeThreshold, haveKeys map[types.PublicKey]bool, height uint64) []Witness {
+	childOptions := make([][]Witness, len(p.Of))
+	for i, sp := range p.Of {
+		childOptions[i] = Satisfactions(sp, haveKeys, height)
+	}
+
+	var merged []Witness
+	var choose func(start int, remaining int, acc Witness)
+	choose = func(start, remaining int, acc Witness) {
+		if remaining == 0 {
+			merged = append(merged, acc)
+			return
+		}
+		for i := start; i <= len(childOptions)-remaining; i++ {
+			for _, opt := range childOptions[i] {
+				next := Witness{
+					Keys:         append(append([]types.PublicKey(nil), acc.Keys...), opt.Keys...),
+					NumPreimages: acc.NumPreimages + opt.NumPreimages,
+				}
+				choose(i+1, remaining-1, next)
+			}
+		}
+	}
+	choose(0, int(p.N), Witness{})
+	return merged
+}
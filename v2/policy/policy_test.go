@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"go.sia.tech/core/v2/types"
+)
+
+func TestAnalyzeThresholdMaxWitnessBytes(t *testing.T) {
+	var pk types.PublicKey
+	p := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyPublicKey(pk), // 64 bytes, 1 sig
+		{Type: types.PolicyTypeUnlockConditions{PublicKeys: []types.PublicKey{pk}, SignaturesRequired: 1}}, // 73 bytes, 1 sig
+	})
+	stats, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The worst-case satisfaction is the uc() branch at 73 bytes, not the
+	// cheapest (pk() at 64 bytes).
+	if stats.MaxWitnessBytes != 73 {
+		t.Fatalf("MaxWitnessBytes = %d, want 73", stats.MaxWitnessBytes)
+	}
+}
+
+func TestAnalyzeThresholdMinSignatures(t *testing.T) {
+	var pk types.PublicKey
+	var h types.Hash256
+	p := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyPublicKey(pk), // 1 sig
+		types.PolicyHash(h),       // 0 sigs
+	})
+	stats, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The minimum-signature satisfaction is the hash() branch (0 sigs), not
+	// the pk() branch (1 sig).
+	if stats.MinSignatures != 0 {
+		t.Fatalf("MinSignatures = %d, want 0", stats.MinSignatures)
+	}
+}
+
+func TestAnalyzeBranches(t *testing.T) {
+	var pkA, pkB types.PublicKey
+	pkB[0] = 1
+	p := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyPublicKey(pkA),
+		types.PolicyPublicKey(pkB),
+	})
+	stats, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Branches) != 2 {
+		t.Fatalf("expected one branch per satisfiable child, got %d", len(stats.Branches))
+	}
+}
+
+// TestAnalyzeBoundsBranchEnumeration guards against the DoS a
+// thresh(N,[M children]) otherwise poses: C(1024,200) satisfying
+// combinations is astronomically larger than maxEnumeratedBranches, so
+// Analyze must still return promptly with Branches omitted, while the
+// aggregate metrics remain exact (computed without enumeration).
+func TestAnalyzeBoundsBranchEnumeration(t *testing.T) {
+	const n, m = 200, 1024 // C(1024,200) satisfying combinations; N must fit in uint8
+	of := make([]types.SpendPolicy, m)
+	for i := range of {
+		var pk types.PublicKey
+		binary.LittleEndian.PutUint32(pk[:], uint32(i))
+		of[i] = types.PolicyPublicKey(pk)
+	}
+	p := types.PolicyThreshold(n, of)
+
+	stats, err := Analyze(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Branches != nil {
+		t.Fatalf("expected Branches to be omitted for a combinatorial thresh(%d,[%d]), got %d entries", n, m, len(stats.Branches))
+	}
+	if stats.MaxWitnessBytes != 64*n {
+		t.Fatalf("MaxWitnessBytes = %d, want %d", stats.MaxWitnessBytes, 64*n)
+	}
+	if stats.MinSignatures != n {
+		t.Fatalf("MinSignatures = %d, want %d", stats.MinSignatures, n)
+	}
+}
+
+func TestAnalyzeUnsatisfiableThreshold(t *testing.T) {
+	var pk types.PublicKey
+	p := types.PolicyThreshold(2, []types.SpendPolicy{types.PolicyPublicKey(pk)})
+	if _, err := Analyze(p); err == nil {
+		t.Fatal("expected error for threshold requiring more sub-policies than provided")
+	}
+}
+
+func TestAnalyzeRejectsInsanePolicy(t *testing.T) {
+	var pk types.PublicKey
+	dup := types.PolicyThreshold(2, []types.SpendPolicy{
+		types.PolicyPublicKey(pk),
+		types.PolicyPublicKey(pk),
+	})
+	if _, err := Analyze(dup); err == nil {
+		t.Fatal("expected error for duplicate key in threshold")
+	}
+
+	unsatisfiable := types.PolicyThreshold(2, []types.SpendPolicy{
+		types.PolicyAbove(100),
+		types.PolicyBefore(50),
+	})
+	if _, err := Analyze(unsatisfiable); err == nil {
+		t.Fatal("expected error for above/before combination that can never be jointly satisfied")
+	}
+}
+
+// TestAnalyzeRejectsDuplicateKeyAcrossNestedThresholds ensures sane catches a
+// key reused at different nesting levels of the same policy tree, not just
+// among a single threshold's direct siblings.
+func TestAnalyzeRejectsDuplicateKeyAcrossNestedThresholds(t *testing.T) {
+	var pk types.PublicKey
+	p := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyPublicKey(pk),
+		types.PolicyThreshold(1, []types.SpendPolicy{
+			types.PolicyPublicKey(pk),
+		}),
+	})
+	if _, err := Analyze(p); err == nil {
+		t.Fatal("expected error for a key reused across nested threshold levels")
+	}
+}
+
+func TestSatisfactionsThreshold(t *testing.T) {
+	var pkA, pkB types.PublicKey
+	pkB[0] = 1
+	p := types.PolicyThreshold(1, []types.SpendPolicy{
+		types.PolicyPublicKey(pkA),
+		types.PolicyPublicKey(pkB),
+	})
+	haveKeys := map[types.PublicKey]bool{pkA: true}
+	ws := Satisfactions(p, haveKeys, 0)
+	if len(ws) != 1 || len(ws[0].Keys) != 1 || ws[0].Keys[0] != pkA {
+		t.Fatalf("expected exactly one witness using pkA, got %+v", ws)
+	}
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
@@ -30,6 +31,23 @@ type PolicyTypePublicKey PublicKey
 // given key.
 func PolicyPublicKey(pk PublicKey) SpendPolicy { return SpendPolicy{PolicyTypePublicKey(pk)} }
 
+// PolicyTypeHash requires the input to reveal a preimage whose hash matches
+// a given value. It enables HTLC-style atomic swaps.
+type PolicyTypeHash Hash256
+
+// PolicyHash returns a policy that requires revealing the preimage of a
+// given hash.
+func PolicyHash(h Hash256) SpendPolicy { return SpendPolicy{PolicyTypeHash(h)} }
+
+// PolicyTypeBefore requires the input to be spent before a given block
+// height. It is the dual of PolicyTypeAbove, and is typically used to
+// construct refund branches in time-locked contracts.
+type PolicyTypeBefore uint64
+
+// PolicyBefore returns a policy that requires the input be spent before a
+// given block height.
+func PolicyBefore(height uint64) SpendPolicy { return SpendPolicy{PolicyTypeBefore(height)} }
+
 // PolicyTypeThreshold requires at least N sub-policies to be satisfied.
 type PolicyTypeThreshold struct {
 	N  uint8
@@ -58,6 +76,8 @@ func (PolicyTypeAbove) isPolicy()            {}
 func (PolicyTypePublicKey) isPolicy()        {}
 func (PolicyTypeThreshold) isPolicy()        {}
 func (PolicyTypeUnlockConditions) isPolicy() {}
+func (PolicyTypeHash) isPolicy()             {}
+func (PolicyTypeBefore) isPolicy()           {}
 
 func (uc PolicyTypeUnlockConditions) root() Hash256 {
 	buf := make([]byte, 65)
@@ -140,6 +160,16 @@ func (p SpendPolicy) String() string {
 		sb.WriteString(hex.EncodeToString(p[:]))
 		sb.WriteByte(')')
 
+	case PolicyTypeHash:
+		sb.WriteString("hash(")
+		sb.WriteString(hex.EncodeToString(p[:]))
+		sb.WriteByte(')')
+
+	case PolicyTypeBefore:
+		sb.WriteString("before(")
+		sb.WriteString(strconv.FormatUint(uint64(p), 10))
+		sb.WriteByte(')')
+
 	case PolicyTypeThreshold:
 		sb.WriteString("thresh(")
 		sb.WriteString(strconv.FormatUint(uint64(p.N), 10))
@@ -220,6 +250,17 @@ func ParseSpendPolicy(s string) (SpendPolicy, error) {
 		_, err = hex.Decode(pk[:], []byte(t))
 		return
 	}
+	parseHash := func() (h Hash256) {
+		t := nextToken()
+		if err != nil {
+			return
+		} else if len(t) != 64 {
+			err = fmt.Errorf("invalid hash length (%d)", len(t))
+			return
+		}
+		_, err = hex.Decode(h[:], []byte(t))
+		return
+	}
 	var parseSpendPolicy func() SpendPolicy
 	parseSpendPolicy = func() SpendPolicy {
 		typ := nextToken()
@@ -228,8 +269,12 @@ func ParseSpendPolicy(s string) (SpendPolicy, error) {
 		switch typ {
 		case "above":
 			return PolicyAbove(parseInt(64))
+		case "before":
+			return PolicyBefore(parseInt(64))
 		case "pk":
 			return PolicyPublicKey(parsePubkey())
+		case "hash":
+			return PolicyHash(parseHash())
 		case "thresh":
 			n := parseInt(8)
 			consume(',')
@@ -299,3 +344,176 @@ func (p SpendPolicy) MarshalJSON() ([]byte, error) {
 func (p *SpendPolicy) UnmarshalJSON(b []byte) (err error) {
 	return p.UnmarshalText(bytes.Trim(b, `"`))
 }
+
+// Binary opcodes for each SpendPolicy variant. These values are part of the
+// on-chain address derivation and must never change; new variants must take
+// the next unused opcode.
+const (
+	opAbove            = 0x01
+	opPublicKey        = 0x02
+	opThreshold        = 0x03
+	opUnlockConditions = 0x04
+	opHash             = 0x05
+	opBefore           = 0x06
+)
+
+// maxPolicyDepth is the maximum recursion depth DecodeFrom will follow
+// through nested PolicyTypeThreshold sub-policies, to bound stack usage when
+// decoding a policy blob from an untrusted peer.
+const maxPolicyDepth = 64
+
+// maxThresholdChildren is the maximum number of sub-policies a single
+// PolicyTypeThreshold may have. EncodeTo panics if this is exceeded, so that
+// Address can never derive an address for a policy DecodeFrom would then
+// refuse to decode back; DecodeFrom enforces the same bound so that a forged
+// child count cannot trigger an unbounded slice allocation before the
+// remaining bytes are validated.
+const maxThresholdChildren = 1024
+
+// EncodeTo implements types.EncoderTo. Each variant is prefixed with a
+// 1-byte opcode so that addresses derived from the binary encoding remain
+// stable as new policy variants are added. Sub-policies are length-prefixed
+// so that a decoder which does not recognize a given opcode can still skip
+// over it.
+func (p SpendPolicy) EncodeTo(e *Encoder) {
+	switch p := p.Type.(type) {
+	case PolicyTypeAbove:
+		e.WriteUint8(opAbove)
+		e.WriteUint64(uint64(p))
+	case PolicyTypePublicKey:
+		e.WriteUint8(opPublicKey)
+		e.Write(p[:])
+	case PolicyTypeThreshold:
+		if len(p.Of) > maxThresholdChildren {
+			panic(fmt.Sprintf("threshold has too many sub-policies (%d > %d)", len(p.Of), maxThresholdChildren))
+		}
+		e.WriteUint8(opThreshold)
+		e.WriteUint8(p.N)
+		e.WriteUint64(uint64(len(p.Of)))
+		for _, sp := range p.Of {
+			e.WritePrefixedBytes(encodedPolicy(sp))
+		}
+	case PolicyTypeUnlockConditions:
+		e.WriteUint8(opUnlockConditions)
+		e.WriteUint64(p.Timelock)
+		e.WriteUint8(uint8(len(p.PublicKeys)))
+		for _, pk := range p.PublicKeys {
+			e.Write(pk[:])
+		}
+		e.WriteUint8(p.SignaturesRequired)
+	case PolicyTypeHash:
+		e.WriteUint8(opHash)
+		e.Write(p[:])
+	case PolicyTypeBefore:
+		e.WriteUint8(opBefore)
+		e.WriteUint64(uint64(p))
+	default:
+		panic(fmt.Sprintf("unhandled policy type %T", p))
+	}
+}
+
+// encodedPolicy returns the binary encoding of p.
+func encodedPolicy(p SpendPolicy) []byte {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	p.EncodeTo(e)
+	e.Flush()
+	return buf.Bytes()
+}
+
+// DecodeFrom implements types.DecoderFrom.
+func (p *SpendPolicy) DecodeFrom(d *Decoder) {
+	p.decodeFrom(d, 0)
+}
+
+func (p *SpendPolicy) decodeFrom(d *Decoder, depth int) {
+	if depth > maxPolicyDepth {
+		d.SetErr(fmt.Errorf("policy exceeds max depth of %d", maxPolicyDepth))
+		return
+	}
+	switch t := d.ReadUint8(); t {
+	case opAbove:
+		p.Type = PolicyTypeAbove(d.ReadUint64())
+	case opPublicKey:
+		var pk PublicKey
+		d.Read(pk[:])
+		p.Type = PolicyTypePublicKey(pk)
+	case opThreshold:
+		n := d.ReadUint8()
+		count := d.ReadUint64()
+		if count > maxThresholdChildren {
+			d.SetErr(fmt.Errorf("threshold has too many sub-policies (%d > %d)", count, maxThresholdChildren))
+			return
+		}
+		of := make([]SpendPolicy, count)
+		for i := range of {
+			sub := d.ReadPrefixedBytes()
+			sd := NewBufDecoder(sub)
+			of[i].decodeFrom(sd, depth+1)
+			if sd.Err() != nil {
+				d.SetErr(sd.Err())
+				return
+			}
+		}
+		p.Type = PolicyTypeThreshold{N: n, Of: of}
+	case opUnlockConditions:
+		uc := PolicyTypeUnlockConditions{Timelock: d.ReadUint64()}
+		uc.PublicKeys = make([]PublicKey, d.ReadUint8())
+		for i := range uc.PublicKeys {
+			d.Read(uc.PublicKeys[i][:])
+		}
+		uc.SignaturesRequired = d.ReadUint8()
+		p.Type = uc
+	case opHash:
+		var h Hash256
+		d.Read(h[:])
+		p.Type = PolicyTypeHash(h)
+	case opBefore:
+		p.Type = PolicyTypeBefore(d.ReadUint64())
+	default:
+		d.SetErr(fmt.Errorf("unknown policy opcode %d", t))
+	}
+}
+
+// A Witness supplies the data needed to satisfy one node of a SpendPolicy
+// tree: a signature for a PolicyTypePublicKey leaf, a preimage for a
+// PolicyTypeHash leaf, or, for a PolicyTypeThreshold, one nested Witness per
+// child of that threshold. A Witness's shape therefore mirrors the shape of
+// the SpendPolicy it satisfies, so a witness built for one branch can never
+// be mistaken for, or silently consumed by, another.
+type Witness struct {
+	Signature *Signature
+	Preimage  []byte
+	Of        []Witness
+}
+
+// Satisfy reports whether witness satisfies policy at the given block
+// height, verifying each PolicyTypePublicKey leaf's signature against
+// sigHash. PolicyTypeUnlockConditions is never satisfied by Satisfy, since
+// legacy signature verification is handled separately by the transaction
+// validation rules.
+func Satisfy(policy SpendPolicy, witness Witness, sigHash Hash256, height uint64) bool {
+	switch p := policy.Type.(type) {
+	case PolicyTypeAbove:
+		return height >= uint64(p)
+	case PolicyTypeBefore:
+		return height < uint64(p)
+	case PolicyTypePublicKey:
+		return witness.Signature != nil && ed25519.Verify(ed25519.PublicKey(p[:]), sigHash[:], witness.Signature[:])
+	case PolicyTypeHash:
+		return witness.Preimage != nil && HashBytes(witness.Preimage) == Hash256(p)
+	case PolicyTypeThreshold:
+		if len(witness.Of) != len(p.Of) {
+			return false
+		}
+		var satisfied uint8
+		for i, sp := range p.Of {
+			if Satisfy(sp, witness.Of[i], sigHash, height) {
+				satisfied++
+			}
+		}
+		return satisfied >= p.N
+	default:
+		return false
+	}
+}
@@ -0,0 +1,314 @@
+package types
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"testing"
+)
+
+func seedPolicies() []SpendPolicy {
+	var pk PublicKey
+	var h Hash256
+	return []SpendPolicy{
+		AnyoneCanSpend(),
+		PolicyAbove(100),
+		PolicyBefore(200),
+		PolicyPublicKey(pk),
+		PolicyHash(h),
+		PolicyThreshold(2, []SpendPolicy{
+			PolicyAbove(1),
+			PolicyPublicKey(pk),
+			PolicyHash(h),
+		}),
+		{PolicyTypeUnlockConditions{
+			Timelock:           10,
+			PublicKeys:         []PublicKey{pk},
+			SignaturesRequired: 1,
+		}},
+	}
+}
+
+func TestPolicyBinaryRoundTrip(t *testing.T) {
+	for _, p := range seedPolicies() {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		p.EncodeTo(e)
+		e.Flush()
+
+		var p2 SpendPolicy
+		d := NewBufDecoder(buf.Bytes())
+		p2.DecodeFrom(d)
+		if err := d.Err(); err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if p.String() != p2.String() {
+			t.Fatalf("round trip mismatch: %v != %v", p, p2)
+		}
+	}
+}
+
+// TestPolicyEncodingGoldenVectors pins the exact encoded bytes of the above,
+// before, publicKey and hash variants, plus the opcode/N/child-count header of a threshold, so that a
+// future change to EncodeTo that alters the on-chain wire format (and
+// therefore every address derived from it) fails this test instead of
+// shipping silently, as the length-prefix rewrite between chunk0-2 and
+// chunk0-5 did.
+func TestPolicyEncodingGoldenVectors(t *testing.T) {
+	encode := func(p SpendPolicy) []byte {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		p.EncodeTo(e)
+		e.Flush()
+		return buf.Bytes()
+	}
+	le64 := func(u uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, u)
+		return b
+	}
+
+	var pk PublicKey
+	pk[0] = 0xAB
+	var h Hash256
+	h[0] = 0xCD
+
+	cases := []struct {
+		name string
+		p    SpendPolicy
+		want []byte
+	}{
+		{"above", PolicyAbove(100), append([]byte{opAbove}, le64(100)...)},
+		{"before", PolicyBefore(200), append([]byte{opBefore}, le64(200)...)},
+		{"publicKey", PolicyPublicKey(pk), append([]byte{opPublicKey}, pk[:]...)},
+		{"hash", PolicyHash(h), append([]byte{opHash}, h[:]...)},
+	}
+	for _, c := range cases {
+		if got := encode(c.p); !bytes.Equal(got, c.want) {
+			t.Errorf("%s: encoded bytes = %x, want %x", c.name, got, c.want)
+		}
+	}
+
+	threshold := PolicyThreshold(1, []SpendPolicy{PolicyAbove(1), PolicyAbove(2)})
+	gotHeader := encode(threshold)[:10]
+	wantHeader := append([]byte{opThreshold, 1}, le64(2)...) // N=1, 2 children
+	if !bytes.Equal(gotHeader, wantHeader) {
+		t.Errorf("threshold header = %x, want %x", gotHeader, wantHeader)
+	}
+}
+
+// TestPolicyThresholdManyChildrenRoundTrip exercises the fix for the
+// opThreshold truncation bug: the sub-policy count was previously encoded
+// as a single byte, silently corrupting any threshold with more than 255
+// children.
+func TestPolicyThresholdManyChildrenRoundTrip(t *testing.T) {
+	of := make([]SpendPolicy, 300)
+	for i := range of {
+		of[i] = PolicyAbove(uint64(i))
+	}
+	p := PolicyThreshold(1, of)
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	p.EncodeTo(e)
+	e.Flush()
+
+	var p2 SpendPolicy
+	d := NewBufDecoder(buf.Bytes())
+	p2.DecodeFrom(d)
+	if err := d.Err(); err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if p.String() != p2.String() {
+		t.Fatal("round trip mismatch for a threshold with more than 255 children")
+	}
+}
+
+// TestPolicyDecodeRejectsForgedChildCount ensures a forged, very large
+// threshold child count is rejected before it can drive an unbounded slice
+// allocation.
+func TestPolicyDecodeRejectsForgedChildCount(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.WriteUint8(opThreshold)
+	e.WriteUint8(1)
+	e.WriteUint64(1 << 40) // forged child count, far larger than any real policy
+	e.Flush()
+
+	var p SpendPolicy
+	d := NewBufDecoder(buf.Bytes())
+	p.DecodeFrom(d)
+	if d.Err() == nil {
+		t.Fatal("expected an error for a forged threshold child count, got none")
+	}
+}
+
+// TestPolicyEncodeRejectsTooManyThresholdChildren ensures EncodeTo enforces
+// the same maxThresholdChildren bound as DecodeFrom, so that Address can
+// never derive an address for a threshold that could never be decoded back.
+func TestPolicyEncodeRejectsTooManyThresholdChildren(t *testing.T) {
+	of := make([]SpendPolicy, maxThresholdChildren+1)
+	for i := range of {
+		of[i] = PolicyAbove(uint64(i))
+	}
+	p := PolicyThreshold(1, of)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected EncodeTo to panic for a threshold with more than maxThresholdChildren children")
+		}
+	}()
+	p.Address()
+}
+
+func TestPolicyTextRoundTrip(t *testing.T) {
+	for _, p := range seedPolicies() {
+		p2, err := ParseSpendPolicy(p.String())
+		if err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if p.String() != p2.String() {
+			t.Fatalf("round trip mismatch: %v != %v", p, p2)
+		}
+	}
+}
+
+func FuzzParseSpendPolicy(f *testing.F) {
+	for _, p := range seedPolicies() {
+		f.Add(p.String())
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		p, err := ParseSpendPolicy(s)
+		if err != nil {
+			return
+		}
+		p2, err := ParseSpendPolicy(p.String())
+		if err != nil {
+			t.Fatalf("re-parse of valid policy failed: %v", err)
+		}
+		if p.String() != p2.String() {
+			t.Fatalf("parse(string(p)) != p: %v != %v", p, p2)
+		}
+	})
+}
+
+func signSigHash(priv ed25519.PrivateKey, sigHash Hash256) Signature {
+	var sig Signature
+	copy(sig[:], ed25519.Sign(priv, sigHash[:]))
+	return sig
+}
+
+func TestSatisfyPublicKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pk PublicKey
+	copy(pk[:], pub)
+	p := PolicyPublicKey(pk)
+
+	var sigHash Hash256
+	sigHash[0] = 1
+	sig := signSigHash(priv, sigHash)
+	if !Satisfy(p, Witness{Signature: &sig}, sigHash, 0) {
+		t.Fatal("expected a valid signature over sigHash to satisfy the policy")
+	}
+
+	wrongHash := sigHash
+	wrongHash[0] = 2
+	if Satisfy(p, Witness{Signature: &sig}, wrongHash, 0) {
+		t.Fatal("a signature over a different sigHash should not satisfy the policy")
+	}
+	if Satisfy(p, Witness{}, sigHash, 0) {
+		t.Fatal("a missing signature should not satisfy the policy")
+	}
+}
+
+func TestSatisfyThresholdDoesNotDesyncBranches(t *testing.T) {
+	_, privA, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubB, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkA, pkB PublicKey
+	copy(pkA[:], privA.Public().(ed25519.PublicKey))
+	copy(pkB[:], pubB)
+
+	p := PolicyThreshold(1, []SpendPolicy{
+		PolicyPublicKey(pkA),
+		PolicyPublicKey(pkB),
+	})
+
+	var sigHash Hash256
+	sigHash[0] = 1
+	sigA := signSigHash(privA, sigHash)
+
+	// A witness built to satisfy the first branch (pkA) must not be
+	// silently credited toward the second branch (pkB); since each
+	// Witness.Of entry maps 1:1 to its policy child, putting sigA in the
+	// slot for pkB should fail both that leaf and the overall threshold.
+	misplaced := Witness{Of: []Witness{{}, {Signature: &sigA}}}
+	if Satisfy(p, misplaced, sigHash, 0) {
+		t.Fatal("a signature placed against the wrong branch should not satisfy the threshold")
+	}
+
+	correct := Witness{Of: []Witness{{Signature: &sigA}, {}}}
+	if !Satisfy(p, correct, sigHash, 0) {
+		t.Fatal("a signature placed against its matching branch should satisfy the threshold")
+	}
+}
+
+// countPolicyNodes counts the total number of SpendPolicy nodes in p's tree,
+// including p itself. It is used to bound a decoded policy's claimed size
+// against the number of input bytes it was decoded from.
+func countPolicyNodes(p SpendPolicy) int {
+	n := 1
+	if t, ok := p.Type.(PolicyTypeThreshold); ok {
+		for _, sp := range t.Of {
+			n += countPolicyNodes(sp)
+		}
+	}
+	return n
+}
+
+func FuzzDecodeSpendPolicy(f *testing.F) {
+	for _, p := range seedPolicies() {
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		p.EncodeTo(e)
+		e.Flush()
+		f.Add(buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var p SpendPolicy
+		d := NewBufDecoder(b)
+		p.DecodeFrom(d)
+		if d.Err() != nil {
+			return
+		}
+		// A decoded policy must never claim more sub-policies, at any depth,
+		// than could plausibly fit in the input: otherwise a short input
+		// could forge a child count that drives an unbounded allocation
+		// before the rest of the bytes are even read.
+		if n := countPolicyNodes(p); n > len(b) {
+			t.Fatalf("decoded policy claims %d nodes from only %d input bytes", n, len(b))
+		}
+		var buf bytes.Buffer
+		e := NewEncoder(&buf)
+		p.EncodeTo(e)
+		e.Flush()
+
+		var p2 SpendPolicy
+		d2 := NewBufDecoder(buf.Bytes())
+		p2.DecodeFrom(d2)
+		if err := d2.Err(); err != nil {
+			t.Fatalf("re-decode of valid policy failed: %v", err)
+		}
+		if p.String() != p2.String() {
+			t.Fatalf("decode(encode(p)) != p: %v != %v", p, p2)
+		}
+	})
+}